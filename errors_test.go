@@ -0,0 +1,41 @@
+package xhyve
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClassifyErrnoAlwaysUnknown(t *testing.T) {
+	err := classifyErrno(13, "Permission denied")
+
+	if err.Code != ErrCodeUnknown {
+		t.Fatalf("classifyErrno code = %v, want ErrCodeUnknown", err.Code)
+	}
+	if err.Errno != 13 {
+		t.Fatalf("classifyErrno Errno = %d, want 13", err.Errno)
+	}
+	if !strings.Contains(err.Error(), "Permission denied") {
+		t.Fatalf("classifyErrno Error() = %q, want it to contain the strerror text", err.Error())
+	}
+}
+
+func TestErrorIsComparesCodeNotValue(t *testing.T) {
+	err := &Error{Code: ErrCodeInvalidKernel, Errno: 2, Message: "no such file"}
+
+	if !errors.Is(err, ErrInvalidKernel) {
+		t.Fatal("errors.Is(err, ErrInvalidKernel) = false, want true: Is should compare Code, ignoring Errno/Message")
+	}
+	if errors.Is(err, ErrHypervisorFrameworkUnavailable) {
+		t.Fatal("errors.Is(err, ErrHypervisorFrameworkUnavailable) = true, want false: different Code")
+	}
+}
+
+func TestErrorMessageIncludesDetail(t *testing.T) {
+	err := &Error{Message: "boot failed", Detail: "kernel decompression error"}
+
+	got := err.Error()
+	if !strings.Contains(got, "boot failed") || !strings.Contains(got, "kernel decompression error") {
+		t.Fatalf("Error() = %q, want it to include both Message and Detail", got)
+	}
+}
@@ -0,0 +1,118 @@
+// +build darwin
+
+// Package factory pre-boots and caches idle VMs so callers get sub-second
+// acquisition instead of paying a full xhyve boot on every request, which
+// matters for Docker/Kubernetes-style workloads that create many
+// short-lived microVMs.
+package factory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	xhyve "github.com/akatrevorjay/xhyve-1"
+	"github.com/akatrevorjay/xhyve-1/hypervisor"
+)
+
+// Factory hands out pre-booted VMs and replenishes its warm pool in the
+// background.
+type Factory interface {
+	// GetVM returns an idle VM if one is warm, otherwise boots one on
+	// demand.
+	GetVM() (*xhyve.VM, error)
+	// Put returns vm to the factory once the caller is done with it. The
+	// xhyve driver has no pause/snapshot primitive yet, so today Put kills
+	// vm and schedules a replacement rather than rewarming it.
+	Put(vm *xhyve.VM)
+}
+
+// New returns a Factory that keeps up to keep VMs booted from template
+// ready for immediate use.
+func New(template hypervisor.BootConfig, keep int) Factory {
+	f := &pool{
+		template: template,
+		keep:     keep,
+		refill:   make(chan struct{}, 1),
+	}
+	go f.refillLoop()
+	f.requestRefill()
+	return f
+}
+
+type pool struct {
+	template hypervisor.BootConfig
+	keep     int
+	refill   chan struct{}
+
+	mu   sync.Mutex
+	idle []*xhyve.VM
+}
+
+func (f *pool) GetVM() (*xhyve.VM, error) {
+	f.mu.Lock()
+	if n := len(f.idle); n > 0 {
+		vm := f.idle[n-1]
+		f.idle = f.idle[:n-1]
+		f.mu.Unlock()
+		f.requestRefill()
+		return vm, nil
+	}
+	f.mu.Unlock()
+
+	return f.bootOne()
+}
+
+func (f *pool) Put(vm *xhyve.VM) {
+	if err := vm.Kill(); err != nil {
+		fmt.Printf("factory: killing returned VM %s: %v\n", vm.ID, err)
+	}
+	f.requestRefill()
+}
+
+func (f *pool) bootOne() (*xhyve.VM, error) {
+	vm, err := xhyve.NewVM(newVMID(), f.template)
+	if err != nil {
+		return nil, err
+	}
+	vm.Start()
+	return vm, nil
+}
+
+// refillLoop tops the idle pool back up to keep whenever requestRefill
+// wakes it, running boots concurrently with whatever GetVM/Put are doing.
+func (f *pool) refillLoop() {
+	for range f.refill {
+		f.mu.Lock()
+		short := f.keep - len(f.idle)
+		f.mu.Unlock()
+
+		for i := 0; i < short; i++ {
+			vm, err := f.bootOne()
+			if err != nil {
+				fmt.Printf("factory: refill: %v\n", err)
+				continue
+			}
+
+			f.mu.Lock()
+			f.idle = append(f.idle, vm)
+			f.mu.Unlock()
+		}
+	}
+}
+
+func (f *pool) requestRefill() {
+	select {
+	case f.refill <- struct{}{}:
+	default:
+	}
+}
+
+// newVMID generates an ID of the form vm-<rand10>, so callers never need
+// to supply one themselves.
+func newVMID() string {
+	buf := make([]byte, 5)
+	rand.Read(buf)
+	return "vm-" + hex.EncodeToString(buf)
+}
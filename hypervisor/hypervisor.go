@@ -0,0 +1,187 @@
+// Package hypervisor defines a driver-agnostic interface for booting and
+// controlling a virtual machine, along with a small registry so that a
+// concrete backend (xhyve today, hyperkit/qemu/vfkit tomorrow) can be
+// selected by name at runtime instead of being hardcoded by the caller.
+package hypervisor
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Disk describes a single block device attached to the guest.
+type Disk struct {
+	// Path is the host path of the disk image or raw device.
+	Path string
+	// Format is the image format, e.g. "raw" or "qcow2". Empty means "raw".
+	Format string
+}
+
+// NIC describes a single network interface attached to the guest.
+type NIC struct {
+	// Type selects the backend network device, e.g. "virtio-net".
+	Type string
+	// MAC is the guest-visible MAC address. Empty means auto-generated.
+	MAC string
+}
+
+// NetworkMode selects how the guest's primary NIC reaches the host/outside
+// world.
+type NetworkMode int
+
+const (
+	// NetworkModeShared is vmnet's NAT mode: the guest gets an address on
+	// a host-internal subnet and reaches the outside world through the
+	// host, the same way vmnet behaved before Network existed.
+	NetworkModeShared NetworkMode = iota
+	// NetworkModeHost restricts the guest to a host-only subnet with no
+	// outside routing.
+	NetworkModeHost
+	// NetworkModeBridged joins the guest directly to a physical host
+	// interface, giving it an address on the host's own network.
+	NetworkModeBridged
+)
+
+func (m NetworkMode) String() string {
+	switch m {
+	case NetworkModeHost:
+		return "host"
+	case NetworkModeBridged:
+		return "bridged"
+	default:
+		return "shared"
+	}
+}
+
+// Network configures the guest's primary NIC, replacing the argv
+// `-s ...,virtio-net` string callers used to compose by hand when they
+// needed anything beyond vmnet's default shared-mode DHCP lease.
+//
+// Network is IPv4-only today; static IPv6 assignment is not implemented.
+// Setting any field other than the zero value causes the driver to
+// synthesize a NIC for it in BootConfig.NICs if the caller didn't already
+// add one, so Network alone is enough to get a reserved lease — callers
+// don't need to also append a matching NIC entry themselves.
+type Network struct {
+	Mode NetworkMode
+	// Subnet is the host-side network in CIDR form, e.g.
+	// "192.168.66.0/24". Left empty, it defaults to vmnet's own
+	// shared-mode subnet (192.168.64.0/24) rather than an arbitrary free
+	// range: this driver does not configure vmnet's subnet itself (that
+	// needs a vendor/xhyve change this package doesn't make), so a
+	// GuestIP reservation only takes effect if Subnet is the one
+	// vmnet is actually serving DHCP on.
+	Subnet string
+	// Gateway is the host's address on Subnet. Left empty, it defaults to
+	// the first usable address in Subnet.
+	Gateway string
+	// GuestIP is the address reserved for this guest. Left empty, the
+	// guest gets whatever address vmnet's DHCP server next hands out.
+	GuestIP string
+	// MAC is the guest NIC's hardware address; required to reserve
+	// GuestIP, since the DHCP reservation is keyed by MAC.
+	MAC string
+}
+
+// BootConfig carries everything a Driver needs to boot a guest, replacing
+// the raw argv strings callers used to assemble by hand.
+type BootConfig struct {
+	CPU     int
+	Memory  int // guest memory in MB
+	Kernel  string
+	Initrd  string
+	Cmdline string
+	Disks   []Disk
+	NICs    []NIC
+	Network Network
+}
+
+// Status is the lifecycle state of a Driver as last observed.
+type Status int
+
+const (
+	StatusUnknown Status = iota
+	StatusBooting
+	StatusRunning
+	StatusPaused
+	StatusStopped
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusBooting:
+		return "booting"
+	case StatusRunning:
+		return "running"
+	case StatusPaused:
+		return "paused"
+	case StatusStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// Driver is implemented by a concrete hypervisor backend.
+//
+// Boot blocks for as long as the guest runs (xhyve's C.run_xhyve call has
+// no asynchronous mode), so a caller that needs to do anything else while
+// the guest is up — control it, multiplex several VMs — must call Boot
+// from its own dedicated goroutine rather than the one Probe was called
+// from. The xhyve package's VM is that wrapper for the xhyve backend: it
+// calls Boot on a runtime.LockOSThread'd goroutine and multiplexes
+// Kill/Shutdown/events around it. Callers that use Probe directly, without
+// VM, take on that goroutine management themselves.
+type Driver interface {
+	// Boot starts the guest described by cfg. It does not return until the
+	// guest has exited; see the interface doc above.
+	Boot(cfg BootConfig) error
+	// Stop asks the guest to shut down.
+	Stop() error
+	// Pause suspends a running guest.
+	Pause() error
+	// Resume resumes a previously paused guest.
+	Resume() error
+	// Kill terminates the guest immediately, without a graceful shutdown.
+	Kill() error
+	// Status reports the driver's last known lifecycle state.
+	Status() Status
+}
+
+// Factory constructs a new, unstarted Driver instance.
+type Factory func() Driver
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Factory{}
+)
+
+// Register makes a driver factory available under name. It is typically
+// called from a backend package's init function. Register panics if name
+// is already registered, mirroring the standard library's database/sql
+// convention for this kind of registry.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("hypervisor: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("hypervisor: Register called twice for driver " + name)
+	}
+	registry[name] = factory
+}
+
+// Probe looks up the driver factory registered under name and returns a
+// freshly constructed Driver.
+func Probe(name string) (Driver, error) {
+	mu.RLock()
+	factory, ok := registry[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("hypervisor: unknown driver %q", name)
+	}
+	return factory(), nil
+}
@@ -0,0 +1,91 @@
+package hypervisor
+
+import "testing"
+
+type fakeDriver struct{}
+
+func (fakeDriver) Boot(BootConfig) error { return nil }
+func (fakeDriver) Stop() error           { return nil }
+func (fakeDriver) Pause() error          { return nil }
+func (fakeDriver) Resume() error         { return nil }
+func (fakeDriver) Kill() error           { return nil }
+func (fakeDriver) Status() Status        { return StatusUnknown }
+
+func withCleanRegistry(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	saved := registry
+	registry = map[string]Factory{}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		registry = saved
+		mu.Unlock()
+	})
+}
+
+func TestRegisterAndProbe(t *testing.T) {
+	withCleanRegistry(t)
+
+	Register("fake", func() Driver { return fakeDriver{} })
+
+	d, err := Probe("fake")
+	if err != nil {
+		t.Fatalf("Probe(\"fake\"): unexpected error: %v", err)
+	}
+	if _, ok := d.(fakeDriver); !ok {
+		t.Fatalf("Probe(\"fake\") = %T, want fakeDriver", d)
+	}
+}
+
+func TestProbeUnknownDriver(t *testing.T) {
+	withCleanRegistry(t)
+
+	if _, err := Probe("does-not-exist"); err == nil {
+		t.Fatal("Probe of an unregistered name: expected an error, got nil")
+	}
+}
+
+func TestRegisterNilFactoryPanics(t *testing.T) {
+	withCleanRegistry(t)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Register with a nil factory: expected a panic, got none")
+		}
+	}()
+	Register("fake", nil)
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	withCleanRegistry(t)
+
+	Register("fake", func() Driver { return fakeDriver{} })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Register called twice for the same name: expected a panic, got none")
+		}
+	}()
+	Register("fake", func() Driver { return fakeDriver{} })
+}
+
+func TestProbeReturnsFreshDriverEachCall(t *testing.T) {
+	withCleanRegistry(t)
+
+	calls := 0
+	Register("fake", func() Driver {
+		calls++
+		return fakeDriver{}
+	})
+
+	if _, err := Probe("fake"); err != nil {
+		t.Fatalf("Probe: unexpected error: %v", err)
+	}
+	if _, err := Probe("fake"); err != nil {
+		t.Fatalf("Probe: unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("factory invoked %d times across two Probe calls, want 2", calls)
+	}
+}
@@ -0,0 +1,132 @@
+package xhyve
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/akatrevorjay/xhyve-1/hypervisor"
+)
+
+// dhcpLeasesPath is where macOS's bootpd keeps the vmnet shared-mode DHCP
+// lease table. Reserving an entry here is what gets a guest MAC a stable
+// IP across boots instead of whatever address the pool hands out next.
+// A var, not a const, so tests can point it at a scratch file instead of
+// the real system path.
+var dhcpLeasesPath = "/var/db/dhcpd_leases"
+
+// defaultSharedSubnet is the subnet vmnet.framework's shared (NAT) mode
+// serves DHCP on by default. This driver does not itself configure
+// vmnet's subnet (that requires a vendor/xhyve change to call
+// vmnet_start_interface with different start/end/mask keys, which this
+// package doesn't make), so a GuestIP reservation only takes effect
+// against the subnet vmnet is actually using — this one, unless whatever
+// started vmnet for this host was separately configured otherwise.
+const defaultSharedSubnet = "192.168.64.0/24"
+
+// wantsNIC reports whether n carries anything a NIC would need to exist
+// for, i.e. anything beyond the zero value ("plain vmnet shared-mode NAT,
+// no reservation"). buildArgv uses this to synthesize a NIC from Network
+// when the caller configured one without also adding a matching entry to
+// BootConfig.NICs.
+func wantsNIC(n hypervisor.Network) bool {
+	return n.Mode != hypervisor.NetworkModeShared || n.Subnet != "" || n.Gateway != "" || n.GuestIP != "" || n.MAC != ""
+}
+
+// resolveNetwork fills in Subnet/Gateway for a Network that asked for a
+// mode or a static GuestIP without specifying addresses, and reserves the
+// DHCP lease requested by GuestIP/MAC. It is a no-op for the zero value,
+// which keeps today's default: plain vmnet shared-mode NAT with whatever
+// address DHCP happens to hand out.
+func resolveNetwork(n hypervisor.Network) (hypervisor.Network, error) {
+	if !wantsNIC(n) {
+		return n, nil
+	}
+
+	// xhyve only drives vmnet in its shared NAT mode today; host-only and
+	// bridged require vmnet.framework calls this driver does not make yet.
+	if n.Mode != hypervisor.NetworkModeShared {
+		return n, fmt.Errorf("xhyve: Network.Mode %s not supported yet, only NetworkModeShared", n.Mode)
+	}
+
+	if n.Subnet == "" {
+		n.Subnet = defaultSharedSubnet
+	}
+
+	if n.Gateway == "" {
+		_, ipnet, err := net.ParseCIDR(n.Subnet)
+		if err != nil {
+			return n, fmt.Errorf("xhyve: invalid Network.Subnet %q: %w", n.Subnet, err)
+		}
+		gw := make(net.IP, len(ipnet.IP))
+		copy(gw, ipnet.IP.To4())
+		gw[len(gw)-1]++
+		n.Gateway = gw.String()
+	}
+
+	if n.GuestIP != "" {
+		if err := reserveLease(n.MAC, n.GuestIP); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// reserveLease appends, or replaces if already present, a static
+// reservation for mac so the guest gets ip from vmnet's DHCP server.
+func reserveLease(mac, ip string) error {
+	if mac == "" {
+		return fmt.Errorf("xhyve: Network.GuestIP requires Network.MAC to key the DHCP reservation")
+	}
+
+	existing, err := ioutil.ReadFile(dhcpLeasesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("xhyve: reading %s: %w", dhcpLeasesPath, err)
+	}
+
+	var out bytes.Buffer
+	var block []string
+	inBlock := false
+	for _, line := range strings.Split(string(existing), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "{":
+			inBlock = true
+			block = []string{line}
+		case inBlock && trimmed == "}":
+			block = append(block, line)
+			if !blockHasMAC(block, mac) {
+				out.WriteString(strings.Join(block, "\n"))
+				out.WriteString("\n")
+			}
+			inBlock = false
+			block = nil
+		case inBlock:
+			block = append(block, line)
+		case trimmed != "":
+			out.WriteString(line)
+			out.WriteString("\n")
+		}
+	}
+
+	fmt.Fprintf(&out, "{\n\tip_address=%s\n\thw_address=1,%s\n\tidentifier=1,%s\n\tlease=0x7fffffff\n}\n", ip, mac, mac)
+
+	return ioutil.WriteFile(dhcpLeasesPath, out.Bytes(), 0644)
+}
+
+// blockHasMAC reports whether a parsed "{ ... }" lease block reserves mac,
+// so reserveLease can drop the whole block atomically instead of slicing
+// into the middle of it.
+func blockHasMAC(block []string, mac string) bool {
+	needle := "hw_address=1," + mac
+	for _, line := range block {
+		if strings.Contains(line, needle) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package xhyve
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akatrevorjay/xhyve-1/hypervisor"
+)
+
+func TestBlockHasMAC(t *testing.T) {
+	block := []string{
+		"{",
+		"\tip_address=192.168.64.10",
+		"\thw_address=1,aa:bb:cc:dd:ee:ff",
+		"\tidentifier=1,aa:bb:cc:dd:ee:ff",
+		"\tlease=0x7fffffff",
+		"}",
+	}
+
+	if !blockHasMAC(block, "aa:bb:cc:dd:ee:ff") {
+		t.Fatal("blockHasMAC: want true for a block reserving this MAC")
+	}
+	if blockHasMAC(block, "11:22:33:44:55:66") {
+		t.Fatal("blockHasMAC: want false for a MAC the block doesn't reserve")
+	}
+}
+
+func withScratchLeasesFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dhcpd_leases")
+	saved := dhcpLeasesPath
+	dhcpLeasesPath = path
+	t.Cleanup(func() { dhcpLeasesPath = saved })
+	return path
+}
+
+func TestReserveLeaseCreatesFile(t *testing.T) {
+	path := withScratchLeasesFile(t)
+
+	if err := reserveLease("aa:bb:cc:dd:ee:ff", "192.168.64.10"); err != nil {
+		t.Fatalf("reserveLease: unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading leases file: %v", err)
+	}
+	if !strings.Contains(string(got), "192.168.64.10") || !strings.Contains(string(got), "aa:bb:cc:dd:ee:ff") {
+		t.Fatalf("leases file = %q, want it to contain the reserved ip/mac", got)
+	}
+}
+
+func TestReserveLeaseReplacesExistingBlockForSameMAC(t *testing.T) {
+	withScratchLeasesFile(t)
+
+	if err := reserveLease("aa:bb:cc:dd:ee:ff", "192.168.64.10"); err != nil {
+		t.Fatalf("first reserveLease: unexpected error: %v", err)
+	}
+	if err := reserveLease("aa:bb:cc:dd:ee:ff", "192.168.64.20"); err != nil {
+		t.Fatalf("second reserveLease: unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(dhcpLeasesPath)
+	if err != nil {
+		t.Fatalf("reading leases file: %v", err)
+	}
+	if strings.Contains(string(got), "192.168.64.10") {
+		t.Fatalf("leases file still contains the stale reservation: %q", got)
+	}
+	if !strings.Contains(string(got), "192.168.64.20") {
+		t.Fatalf("leases file missing the new reservation: %q", got)
+	}
+}
+
+func TestReserveLeasePreservesOtherBlocks(t *testing.T) {
+	withScratchLeasesFile(t)
+
+	if err := reserveLease("11:11:11:11:11:11", "192.168.64.11"); err != nil {
+		t.Fatalf("reserveLease (other MAC): unexpected error: %v", err)
+	}
+	if err := reserveLease("aa:bb:cc:dd:ee:ff", "192.168.64.10"); err != nil {
+		t.Fatalf("reserveLease: unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(dhcpLeasesPath)
+	if err != nil {
+		t.Fatalf("reading leases file: %v", err)
+	}
+	if !strings.Contains(string(got), "192.168.64.11") {
+		t.Fatalf("leases file lost the unrelated block: %q", got)
+	}
+	if !strings.Contains(string(got), "192.168.64.10") {
+		t.Fatalf("leases file missing the new block: %q", got)
+	}
+}
+
+func TestReserveLeaseRequiresMAC(t *testing.T) {
+	withScratchLeasesFile(t)
+
+	if err := reserveLease("", "192.168.64.10"); err == nil {
+		t.Fatal("reserveLease with no MAC: expected an error, got nil")
+	}
+}
+
+func TestResolveNetworkZeroValueIsNoop(t *testing.T) {
+	n, err := resolveNetwork(hypervisor.Network{})
+	if err != nil {
+		t.Fatalf("resolveNetwork(zero value): unexpected error: %v", err)
+	}
+	if n != (hypervisor.Network{}) {
+		t.Fatalf("resolveNetwork(zero value) = %+v, want it unchanged", n)
+	}
+}
+
+func TestResolveNetworkDefaultsSubnetAndGateway(t *testing.T) {
+	withScratchLeasesFile(t)
+
+	n, err := resolveNetwork(hypervisor.Network{MAC: "aa:bb:cc:dd:ee:ff", GuestIP: "192.168.64.10"})
+	if err != nil {
+		t.Fatalf("resolveNetwork: unexpected error: %v", err)
+	}
+	if n.Subnet != defaultSharedSubnet {
+		t.Fatalf("resolveNetwork Subnet = %q, want vmnet's default %q", n.Subnet, defaultSharedSubnet)
+	}
+	if n.Gateway != "192.168.64.1" {
+		t.Fatalf("resolveNetwork Gateway = %q, want 192.168.64.1", n.Gateway)
+	}
+}
+
+func TestResolveNetworkRejectsUnsupportedMode(t *testing.T) {
+	_, err := resolveNetwork(hypervisor.Network{Mode: hypervisor.NetworkModeBridged})
+	if err == nil {
+		t.Fatal("resolveNetwork with NetworkModeBridged: expected an error, got nil")
+	}
+}
+
+func TestWantsNIC(t *testing.T) {
+	cases := []struct {
+		name string
+		n    hypervisor.Network
+		want bool
+	}{
+		{"zero value", hypervisor.Network{}, false},
+		{"mac set", hypervisor.Network{MAC: "aa:bb:cc:dd:ee:ff"}, true},
+		{"guest ip set", hypervisor.Network{GuestIP: "192.168.64.10"}, true},
+		{"non-shared mode", hypervisor.Network{Mode: hypervisor.NetworkModeHost}, true},
+	}
+	for _, c := range cases {
+		if got := wantsNIC(c.n); got != c.want {
+			t.Errorf("wantsNIC(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
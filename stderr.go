@@ -0,0 +1,104 @@
+// +build darwin
+
+package xhyve
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// stderrCaptureWindow bounds how long a single Boot holds the
+	// process-wide stderr fd redirected. xhyve's kernel-load failures
+	// surface almost immediately, so there is little value (and real
+	// cost, see stderrCaptureMax) in holding the redirect for a guest's
+	// entire, potentially unbounded, run.
+	stderrCaptureWindow = 10 * time.Second
+	// stderrCaptureMax bounds how much of a long-running guest's stderr
+	// chatter is kept in memory.
+	stderrCaptureMax = 64 * 1024
+)
+
+// stderrCaptureSlot is a non-blocking mutex (a capacity-1 channel) rather
+// than a sync.Mutex: fd 2 is a single process-wide resource, so only one
+// Boot may redirect it at a time, and a VM that can't get the slot should
+// boot without a captured Detail rather than corrupt another VM's
+// redirect-and-restore.
+var stderrCaptureSlot = make(chan struct{}, 1)
+
+// captureStderr redirects the process's stderr fd to an in-memory pipe for
+// up to stderrCaptureWindow, so a failure like a bad kernel image can
+// surface the C side's diagnostic output as Error.Detail instead of being
+// lost to the terminal. It restores stderr on its own after the window
+// elapses even if the guest is still running, and the returned stop func
+// restores it (idempotently) early and returns whatever was captured.
+//
+// If another Boot already holds the capture slot, captureStderr returns a
+// stop func that is a no-op returning "": the caller still boots, it just
+// won't get a Detail for this failure.
+func captureStderr() (stop func() string, err error) {
+	select {
+	case stderrCaptureSlot <- struct{}{}:
+	default:
+		return func() string { return "" }, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		<-stderrCaptureSlot
+		return nil, err
+	}
+
+	origFd, err := syscall.Dup(int(os.Stderr.Fd()))
+	if err != nil {
+		r.Close()
+		w.Close()
+		<-stderrCaptureSlot
+		return nil, err
+	}
+
+	if err := syscall.Dup2(int(w.Fd()), int(os.Stderr.Fd())); err != nil {
+		syscall.Close(origFd)
+		r.Close()
+		w.Close()
+		<-stderrCaptureSlot
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, io.LimitReader(r, stderrCaptureMax))
+		// Keep draining past the cap so a chatty guest never blocks on a
+		// full pipe; the excess is simply discarded.
+		io.Copy(ioutil.Discard, r)
+		close(done)
+	}()
+
+	var once sync.Once
+	var result string
+	restore := func() {
+		once.Do(func() {
+			w.Close()
+			syscall.Dup2(origFd, int(os.Stderr.Fd()))
+			syscall.Close(origFd)
+			<-done
+			r.Close()
+			result = buf.String()
+			<-stderrCaptureSlot
+		})
+	}
+
+	timer := time.AfterFunc(stderrCaptureWindow, restore)
+
+	return func() string {
+		timer.Stop()
+		restore()
+		return result
+	}, nil
+}
@@ -0,0 +1,105 @@
+// +build darwin
+
+package xhyve
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// trapOnce ensures VM.Start only installs the process-wide signal trap
+// once, no matter how many VMs it is started with WithTrap(true) for.
+var trapOnce sync.Once
+
+var (
+	trappedMu sync.Mutex
+	trapped   = map[*VM]struct{}{}
+)
+
+func registerTrappedVM(vm *VM) {
+	trappedMu.Lock()
+	trapped[vm] = struct{}{}
+	trappedMu.Unlock()
+}
+
+func unregisterTrappedVM(vm *VM) {
+	trappedMu.Lock()
+	delete(trapped, vm)
+	trappedMu.Unlock()
+}
+
+// shutdownTrappedVMs gracefully shuts down every VM started with trapping
+// enabled, in parallel, giving each up to 5 seconds before Shutdown itself
+// escalates to Kill.
+func shutdownTrappedVMs() {
+	trappedMu.Lock()
+	vms := make([]*VM, 0, len(trapped))
+	for vm := range trapped {
+		vms = append(vms, vm)
+	}
+	trappedMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, vm := range vms {
+		wg.Add(1)
+		go func(vm *VM) {
+			defer wg.Done()
+			if err := vm.Shutdown(5 * time.Second); err != nil {
+				fmt.Printf("xhyve: shutting down %s: %v\n", vm.ID, err)
+			}
+		}(vm)
+	}
+	wg.Wait()
+}
+
+// Trap installs handlers for SIGINT/SIGTERM/SIGQUIT so an embedding
+// process can kill a wedged hypervisor instead of hanging forever.
+//
+// On the first SIGINT/SIGTERM, cleanup runs once. On the third repeated
+// SIGINT/SIGTERM it force-exits without running cleanup again, for an
+// operator whose cleanup itself is stuck. SIGQUIT dumps every goroutine's
+// stack before exiting, for diagnosing a wedged process without killing
+// it blind.
+//
+// Every call installs its own handlers, each with its own interrupt count
+// and its own cleanup. VM.Start calls it automatically, at most once per
+// process (see trapOnce), unless started with WithTrap(false).
+func Trap(cleanup func()) {
+	sigCh := make(chan os.Signal, 4)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	var interrupts int32
+	var cleanupOnce sync.Once
+
+	go func() {
+		for sig := range sigCh {
+			if sig == syscall.SIGQUIT {
+				dumpStacks()
+				os.Exit(1)
+			}
+
+			if atomic.AddInt32(&interrupts, 1) >= 3 {
+				fmt.Println("xhyve: repeated interrupt, force-exiting without cleanup")
+				os.Exit(1)
+			}
+
+			// Run cleanup in its own goroutine so this loop keeps reading
+			// signals while cleanup (e.g. shutting down several VMs) is
+			// still in flight; otherwise a stuck cleanup blocks the count
+			// that is supposed to let an operator force-exit past it.
+			go cleanupOnce.Do(cleanup)
+		}
+	}()
+}
+
+func dumpStacks() {
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	fmt.Fprintln(os.Stderr, string(buf[:n]))
+}
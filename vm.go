@@ -0,0 +1,253 @@
+// +build darwin
+
+package xhyve
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/akatrevorjay/xhyve-1/hypervisor"
+)
+
+// Event is a VM lifecycle notification delivered on VM.Events().
+type Event int
+
+const (
+	E_VM_STARTING Event = iota
+	E_VM_RUNNING
+	E_VM_SHUTDOWN
+	E_VM_KILLED
+)
+
+func (e Event) String() string {
+	switch e {
+	case E_VM_STARTING:
+		return "VM_STARTING"
+	case E_VM_RUNNING:
+		return "VM_RUNNING"
+	case E_VM_SHUTDOWN:
+		return "VM_SHUTDOWN"
+	case E_VM_KILLED:
+		return "VM_KILLED"
+	default:
+		return "VM_UNKNOWN"
+	}
+}
+
+type ctrlKind int
+
+const (
+	ctrlShutdown ctrlKind = iota
+	ctrlKill
+)
+
+type ctrlRequest struct {
+	kind  ctrlKind
+	reply chan error
+}
+
+// VM owns a single guest's lifecycle. C.run_xhyve blocks the OS thread for
+// as long as the guest runs, so Boot happens on its own
+// runtime.LockOSThread'd goroutine; a second goroutine multiplexes control
+// requests (Kill, Shutdown) with that goroutine's completion over
+// channels, so callers never touch the locked goroutine directly and many
+// VMs can run concurrently in one process.
+type VM struct {
+	ID  string
+	cfg hypervisor.BootConfig
+
+	driver *Driver
+	events chan Event
+	ctrl   chan ctrlRequest
+	done   chan error
+	exited chan struct{}
+
+	// doneOnce guards vm.done: whichever fires first between Boot actually
+	// returning and the driver's exited signal (see watchExit) decides the
+	// outcome, and the other must become a no-op instead of blocking
+	// forever on the now-full, capacity-1 vm.done.
+	doneOnce sync.Once
+	// bootReturned is closed once vm.driver.Boot returns, so watchExit can
+	// stop waiting on a guest-exit signal that a Boot failing before the
+	// guest ever started (e.g. a bad argv) will never send.
+	bootReturned chan struct{}
+}
+
+// NewVM creates a VM bound to id that will boot cfg once Start is called.
+func NewVM(id string, cfg hypervisor.BootConfig) (*VM, error) {
+	if id == "" {
+		return nil, fmt.Errorf("xhyve: NewVM requires a non-empty id")
+	}
+	return &VM{
+		ID:           id,
+		cfg:          cfg,
+		driver:       newDriver(),
+		events:       make(chan Event, 4),
+		ctrl:         make(chan ctrlRequest),
+		done:         make(chan error, 1),
+		exited:       make(chan struct{}),
+		bootReturned: make(chan struct{}),
+	}, nil
+}
+
+// Events returns the channel lifecycle events are delivered on. It is
+// closed once the VM has shut down or been killed.
+func (vm *VM) Events() <-chan Event { return vm.events }
+
+// StartOption customizes VM.Start.
+type StartOption func(*startOptions)
+
+type startOptions struct {
+	trap bool
+}
+
+// WithTrap controls whether Start registers this VM with the process-wide
+// signal trap installed by Trap. It defaults to true: an operator hitting
+// Ctrl-C should shut down every VM it started, not just the one they were
+// watching.
+func WithTrap(enabled bool) StartOption {
+	return func(o *startOptions) { o.trap = enabled }
+}
+
+// Start boots the VM on its dedicated goroutine and returns immediately;
+// progress is reported on Events().
+func (vm *VM) Start(opts ...StartOption) {
+	so := startOptions{trap: true}
+	for _, opt := range opts {
+		opt(&so)
+	}
+
+	if so.trap {
+		trapOnce.Do(func() { Trap(shutdownTrappedVMs) })
+		registerTrappedVM(vm)
+	}
+
+	go vm.bootLoop()
+	go vm.watchExit()
+	go vm.ctrlLoop()
+}
+
+func (vm *VM) bootLoop() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	vm.events <- E_VM_STARTING
+	// Boot blocks until the guest exits, so E_VM_RUNNING can't be sent
+	// unconditionally up front: a guest that fails to boot would still be
+	// reported as running. onRunning fires exactly when the driver
+	// reaches StatusRunning, just before the blocking call.
+	vm.driver.onRunning = func() { vm.events <- E_VM_RUNNING }
+	err := vm.driver.Boot(vm.cfg)
+	close(vm.bootReturned)
+	vm.signalDone(err)
+}
+
+// watchExit races the driver's exited signal against Boot actually
+// returning (see bootLoop and Driver.exited): if C.run_xhyve's call frame
+// never unwinds back to Boot after the guest exits, Boot blocks forever
+// and only this goroutine ever reports completion.
+func (vm *VM) watchExit() {
+	select {
+	case status := <-vm.driver.exited:
+		var err error
+		if status != 0 {
+			err = fmt.Errorf("xhyve: guest exited with status %d", status)
+		}
+		vm.signalDone(err)
+	case <-vm.bootReturned:
+		// Boot already reported its own outcome (e.g. it failed before the
+		// guest ever started, so go_callback_exit never ran).
+	}
+}
+
+// signalDone delivers err as vm.done's single outcome. It is called from
+// both bootLoop and watchExit, since either may observe guest completion
+// first; doneOnce ensures only the first one lands on the capacity-1
+// vm.done and the other is a no-op rather than a permanent block.
+func (vm *VM) signalDone(err error) {
+	vm.doneOnce.Do(func() { vm.done <- err })
+}
+
+// ctrlLoop owns the decision of what the boot goroutine's exit means: a
+// natural return is a shutdown, a return following a Kill/Shutdown request
+// is reported as such to Events().
+func (vm *VM) ctrlLoop() {
+	killed := false
+
+	for {
+		select {
+		case req := <-vm.ctrl:
+			var err error
+			if req.kind == ctrlKill {
+				killed = true
+				err = vm.driver.Kill()
+			} else {
+				err = vm.driver.Stop()
+			}
+			req.reply <- err
+
+		case err := <-vm.done:
+			if err != nil && !killed {
+				killed = true
+			}
+			if killed {
+				vm.events <- E_VM_KILLED
+			} else {
+				vm.events <- E_VM_SHUTDOWN
+			}
+			close(vm.events)
+			close(vm.exited)
+			unregisterTrappedVM(vm)
+			return
+		}
+	}
+}
+
+// Kill asks the driver to tear the guest down; see Driver.Kill for what
+// that means for the xhyve driver.
+func (vm *VM) Kill() error { return vm.request(ctrlKill) }
+
+// Pause suspends a running guest. Not yet supported by the xhyve driver.
+func (vm *VM) Pause() error { return vm.driver.Pause() }
+
+// Resume resumes a previously paused guest. Not yet supported by the
+// xhyve driver.
+func (vm *VM) Resume() error { return vm.driver.Resume() }
+
+// Shutdown asks the guest to power off gracefully, escalating to Kill if
+// it has not exited within timeout.
+func (vm *VM) Shutdown(timeout time.Duration) error {
+	if err := vm.request(ctrlShutdown); err != nil {
+		return err
+	}
+
+	select {
+	case <-vm.exited:
+		return nil
+	case <-time.After(timeout):
+		return vm.Kill()
+	}
+}
+
+// request sends a control request to ctrlLoop. ctrlLoop exits as soon as
+// the guest does, so request also selects on vm.exited: without that, a
+// Kill/Shutdown issued after a VM has already exited (e.g. a short-lived
+// guest that powers itself off before factory.Put gets to it) would block
+// forever sending on the now-unread vm.ctrl channel.
+func (vm *VM) request(kind ctrlKind) error {
+	reply := make(chan error, 1)
+	select {
+	case vm.ctrl <- ctrlRequest{kind: kind, reply: reply}:
+	case <-vm.exited:
+		return fmt.Errorf("xhyve: VM %s is no longer running", vm.ID)
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-vm.exited:
+		return fmt.Errorf("xhyve: VM %s is no longer running", vm.ID)
+	}
+}
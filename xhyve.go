@@ -1,11 +1,15 @@
 // +build darwin
 
+// Package xhyve is the reference hypervisor.Driver implementation, backed
+// by the xhyve hypervisor (https://github.com/mist64/xhyve) via cgo.
 package xhyve
 
 // #cgo CFLAGS: -I${SRCDIR}/vendor/xhyve/include -x c -std=c11 -fno-common -arch x86_64 -DXHYVE_CONFIG_ASSERT -DVERSION=v0.2.0 -Os -fstrict-aliasing -Wno-unknown-warning-option -Wno-reserved-id-macro -pedantic -fmessage-length=152 -fdiagnostics-show-note-include-stack -fmacro-backtrace-limit=0
 // #cgo LDFLAGS: -L${SRCDIR} -arch x86_64 -framework Hypervisor -framework vmnet
 // #include <xhyve/xhyve.h>
 // #include <string.h>
+// #include <signal.h>
+// #include <pthread.h>
 //
 // void go_callback_exit(int status);
 import "C"
@@ -13,38 +17,302 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
+	"sync"
 	"unsafe"
+
+	"github.com/akatrevorjay/xhyve-1/hypervisor"
 )
 
-var argv []*C.char
+func init() {
+	runtime.LockOSThread()
+	hypervisor.Register("xhyve", func() hypervisor.Driver { return newDriver() })
+}
+
+// newDriver returns a Driver with its exited channel ready to receive,
+// so go_callback_exit always has somewhere to send even if Boot's caller
+// hasn't started listening yet (the channel is buffered 1, so the send
+// never blocks on a listener).
+func newDriver() *Driver {
+	return &Driver{exited: make(chan int, 1)}
+}
+
+// liveDrivers maps the OS thread a Driver's Boot call is running on back to
+// that Driver, so go_callback_exit (a single process-wide C export) can free
+// the right Driver's argv instead of reaching for a package-global slice.
+var (
+	liveMu      sync.Mutex
+	liveDrivers = map[C.pthread_t]*Driver{}
+)
 
 //export go_callback_exit
 func go_callback_exit(status C.int) {
+	tid := C.pthread_self()
+
+	liveMu.Lock()
+	d := liveDrivers[tid]
+	liveMu.Unlock()
+
+	if d == nil {
+		fmt.Println("xhyve: go_callback_exit on an unregistered thread, nothing to release")
+		return
+	}
+
 	fmt.Printf("Releasing memory in Go land... ")
-	for _, arg := range argv {
+	for _, arg := range d.argv {
 		C.free(unsafe.Pointer(arg))
 	}
+	d.argv = nil
 	fmt.Println("done")
 
-	os.Exit(int(status))
+	// Deliberately no os.Exit here: exiting would tear down every other
+	// VM's Boot call in this process.
+	//
+	// Whether C.run_xhyve's call frame actually unwinds back to Boot after
+	// this point is not something this package can verify without
+	// vendor/xhyve's source; the original code's os.Exit suggests it may
+	// not. So this status is delivered here, from the one place that's
+	// guaranteed to run on a normal guest exit, rather than only via
+	// Boot's return value: send it on the driver's exited channel and let
+	// whichever arrives first — this signal or Boot actually returning —
+	// decide the outcome. See Driver.exited and VM's watcher goroutine.
+	select {
+	case d.exited <- int(status):
+	default:
+		// Already delivered, or nobody is listening (a bare Probe+Boot
+		// caller with no watcher); either way there is nothing else to do.
+	}
 }
 
-func init() {
-	runtime.LockOSThread()
+// Driver is the xhyve hypervisor.Driver implementation. A Driver is only
+// good for a single Boot call; a VM constructs a fresh one per guest.
+type Driver struct {
+	status hypervisor.Status
+
+	argv []*C.char
+	tid  C.pthread_t
+
+	// exited receives the guest's exit status from go_callback_exit, the
+	// moment it runs — independent of whether C.run_xhyve's call frame
+	// ever unwinds back to Boot afterward. Buffered 1 so the send in
+	// go_callback_exit never blocks. Always non-nil: set by newDriver.
+	exited chan int
+
+	// onRunning, if set, is called the moment status flips to
+	// StatusRunning, just before the blocking run_xhyve call. Boot itself
+	// doesn't return until the guest exits, so this is how VM's bootLoop
+	// learns exactly when to report E_VM_RUNNING instead of guessing.
+	onRunning func()
 }
 
-// Run runs xhyve hypervisor.
-func Run(params []string) error {
+// Boot assembles an xhyve argv from cfg and hands it to the C shim. It
+// blocks on the calling OS thread for as long as the guest is running, so
+// callers that need to multiplex other work should call it from a
+// dedicated runtime.LockOSThread'd goroutine (see VM).
+func (d *Driver) Boot(cfg hypervisor.BootConfig) error {
+	d.setStatus(hypervisor.StatusBooting)
+
+	if err := preflight(cfg); err != nil {
+		d.setStatus(hypervisor.StatusStopped)
+		return err
+	}
+
+	network, err := resolveNetwork(cfg.Network)
+	if err != nil {
+		d.setStatus(hypervisor.StatusStopped)
+		return err
+	}
+	cfg.Network = network
+
+	params := buildArgv(cfg)
+
 	argc := C.int(len(params))
-	argv = make([]*C.char, argc)
+	d.argv = make([]*C.char, argc)
 	for i, arg := range params {
-		argv[i] = C.CString(arg)
+		d.argv[i] = C.CString(arg)
+	}
+
+	tid := C.pthread_self()
+	liveMu.Lock()
+	d.tid = tid
+	liveDrivers[tid] = d
+	liveMu.Unlock()
+	defer func() {
+		liveMu.Lock()
+		delete(liveDrivers, tid)
+		liveMu.Unlock()
+	}()
+
+	restoreStderr, captureErr := captureStderr()
+	if captureErr != nil {
+		fmt.Printf("xhyve: capturing guest boot stderr: %v\n", captureErr)
+	}
+
+	d.setStatus(hypervisor.StatusRunning)
+	if d.onRunning != nil {
+		d.onRunning()
+	}
+	if errno := C.run_xhyve(argc, &d.argv[0]); errno != 0 {
+		d.setStatus(hypervisor.StatusStopped)
+
+		var detail string
+		if restoreStderr != nil {
+			detail = restoreStderr()
+		}
+
+		xerr := classifyErrno(int(errno), C.GoString(C.strerror(errno)))
+		xerr.Detail = detail
+		return xerr
 	}
+	if restoreStderr != nil {
+		restoreStderr()
+	}
+
+	d.setStatus(hypervisor.StatusStopped)
+	return nil
+}
 
-	if err := C.run_xhyve(argc, &argv[0]); err != 0 {
-		fmt.Printf("ERROR => %s\n", C.GoString(C.strerror(err)))
-		return fmt.Errorf("Error initializing hypervisor")
+// preflight catches the two failure causes this package can actually tell
+// apart without vendor/xhyve's C shim reporting a discriminated code:
+// Hypervisor.framework access and a missing/unreadable kernel image. Both
+// surface as an opaque errno from run_xhyve otherwise, so checking them
+// up front is what lets Boot return ErrHypervisorFrameworkUnavailable and
+// ErrInvalidKernel instead of ErrCodeUnknown for the cases callers most
+// often need to distinguish.
+func preflight(cfg hypervisor.BootConfig) error {
+	// Hypervisor.framework requires either running as root or the
+	// com.apple.security.hypervisor entitlement; root is the only one of
+	// the two this package can check without involving the Security
+	// framework, but it is also by far the more common setup, so failing
+	// fast on it avoids a confusing run_xhyve errno for the typical case.
+	// A process that is not root is refused here even if it holds the
+	// entitlement.
+	if os.Geteuid() != 0 {
+		return &Error{
+			Code:    ErrCodeHypervisorFrameworkUnavailable,
+			Message: ErrHypervisorFrameworkUnavailable.Message,
+		}
 	}
 
+	for _, path := range []string{cfg.Kernel, cfg.Initrd} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return &Error{
+				Code:    ErrCodeInvalidKernel,
+				Message: fmt.Sprintf("%s: %s", ErrInvalidKernel.Message, err),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stop asks the guest to shut down by sending SIGTERM to the thread
+// blocked in Boot, which xhyve's own signal handler treats as a request
+// for a clean teardown.
+func (d *Driver) Stop() error { return d.signal(C.SIGTERM) }
+
+// Pause is not yet supported: xhyve has no live-pause primitive to call.
+func (d *Driver) Pause() error { return fmt.Errorf("xhyve: Pause not supported yet") }
+
+// Resume is not yet supported, see Pause.
+func (d *Driver) Resume() error { return fmt.Errorf("xhyve: Resume not supported yet") }
+
+// Kill sends the same SIGTERM as Stop, since xhyve does not expose a
+// separate forceful teardown; the guest gets the same clean-teardown
+// request and is not torn down any faster than by Stop.
+func (d *Driver) Kill() error { return d.signal(C.SIGTERM) }
+
+// Status reports the driver's last known lifecycle state.
+func (d *Driver) Status() hypervisor.Status {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	return d.status
+}
+
+// setStatus updates d.status under liveMu: Boot writes it from its own
+// goroutine while Status reads it from whichever goroutine called Status,
+// so it needs the same guard liveDrivers and d.tid already use rather than
+// a bare field write.
+func (d *Driver) setStatus(s hypervisor.Status) {
+	liveMu.Lock()
+	d.status = s
+	liveMu.Unlock()
+}
+
+func (d *Driver) signal(sig C.int) error {
+	liveMu.Lock()
+	tid := d.tid
+	liveMu.Unlock()
+
+	if tid == 0 {
+		return fmt.Errorf("xhyve: no running guest to signal")
+	}
+	if ret := C.pthread_kill(tid, sig); ret != 0 {
+		return fmt.Errorf("xhyve: pthread_kill: %s", C.GoString(C.strerror(ret)))
+	}
 	return nil
 }
+
+// buildArgv translates a BootConfig into the argv xhyve expects, replacing
+// the argv string slices callers used to build by hand.
+func buildArgv(cfg hypervisor.BootConfig) []string {
+	params := []string{
+		"xhyve",
+		"-A",
+		"-c", strconv.Itoa(cfg.CPU),
+		"-m", strconv.Itoa(cfg.Memory) + "M",
+		"-s", "0:0,hostbridge",
+		"-s", "31,lpc",
+		"-l", "com1,stdio",
+	}
+
+	slot := 2
+	for _, disk := range cfg.Disks {
+		format := disk.Format
+		if format == "" {
+			format = "raw"
+		}
+		params = append(params, "-s", fmt.Sprintf("%d,ahci-hd,%s,format=%s", slot, disk.Path, format))
+		slot++
+	}
+
+	// A caller that set up Network without also appending a NIC for it
+	// would otherwise get no NIC at all: Network's reservation is keyed by
+	// MAC, and that MAC has to actually reach the guest as a virtio-net
+	// device for vmnet's DHCP server to ever see it.
+	nics := cfg.NICs
+	if len(nics) == 0 && wantsNIC(cfg.Network) {
+		nics = []hypervisor.NIC{{Type: "virtio-net", MAC: cfg.Network.MAC}}
+	}
+
+	// xhyve's virtio-net device only understands a "mac=" parameter; it has
+	// no argv-level knob for vmnet mode/subnet/gateway. Network.Mode today
+	// is always vmnet's shared NAT mode under the hood, and
+	// Network.GuestIP instead drives the host-side DHCP reservation in
+	// reserveLease, keyed by this same MAC. Network.Subnet/Gateway are
+	// filled in by resolveNetwork but reach neither argv nor the reservation.
+	for i, nic := range nics {
+		typ := nic.Type
+		if typ == "" {
+			typ = "virtio-net"
+		}
+		mac := nic.MAC
+		if i == 0 && cfg.Network.MAC != "" {
+			mac = cfg.Network.MAC
+		}
+
+		spec := fmt.Sprintf("%d,%s", slot, typ)
+		if mac != "" {
+			spec += ",mac=" + mac
+		}
+		params = append(params, "-s", spec)
+		slot++
+	}
+
+	params = append(params, "-f", fmt.Sprintf("kexec,%s,%s,%s", cfg.Kernel, cfg.Initrd, cfg.Cmdline))
+
+	return params
+}